@@ -6,12 +6,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 )
@@ -73,10 +77,29 @@ Run a script block-by-block, showing output after each block.
 Blocks are separated by blank lines.
 
 Options:
-  --hierarchical    Use '## ' headers to separate blocks instead of blank lines
-  --help, -h        Show this help message
+  --hierarchical         Use '## ' headers to separate blocks instead of blank lines
+  --markdown             Treat the script as Markdown and run its fenced code blocks
+  --output-ipynb <path>  Write a Jupyter notebook with each cell's captured output to <path>
+  --session              Run blocks through a persistent interpreter session, so state
+                         (e.g. variables) carries over from one block to the next
+  --test                 Check each block's captured output against its expect-output,
+                         expect-exit and expect-error-match directives
+  --update               With --test, rewrite stale expect-output directives in place
+  --interactive          Pause after each block to continue, skip, re-run, edit or quit
+  --only <name>          Run only the named block and its transitive dependencies
+  --from <name>          Run the named block, everything after it, and their dependencies
+  --list                 Print the discovered block graph (names and depends) without running
+  --help, -h             Show this help message
 
-The interpreter is determined from the shebang line.
+The interpreter is determined from the shebang line, or, in --markdown mode,
+from each fenced code block's info string (a block with no info string falls
+back to the script's first-line shebang, if any). For a .ipynb input file, the kernel in
+metadata.kernelspec is used instead of a shebang.
+
+A block can be named for --only/--from/--list: a '## name' header in
+--hierarchical mode, or a '# block: name' comment on the first line of a
+blank-line-separated block. Either form may be followed by
+'depends: a, b' to declare which named blocks must run first.
 
 Wrapper search paths:
 `)
@@ -186,6 +209,701 @@ func splitBlocksBlankLines(content string) []string {
 	return blocks
 }
 
+// mdBlock is a single fenced code block extracted from a Markdown document,
+// along with the language tag from its opening fence (e.g. "bash" in
+// ```bash). lang is empty if the fence had no info string.
+type mdBlock struct {
+	lang string
+	code string
+}
+
+var fenceRe = regexp.MustCompile("^```\\s*([A-Za-z0-9_+-]*)")
+
+// splitBlocksMarkdown extracts fenced code blocks from a Markdown document,
+// ignoring any prose between them. Each block records the language tag (if
+// any) from its opening fence so the caller can pick a wrapper per block.
+func splitBlocksMarkdown(content string) []mdBlock {
+	var blocks []mdBlock
+	var lang string
+	var current []string
+	inFence := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inFence {
+			if matches := fenceRe.FindStringSubmatch(line); matches != nil {
+				inFence = true
+				lang = matches[1]
+				current = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = false
+			blocks = append(blocks, mdBlock{lang: lang, code: strings.Join(current, "\n")})
+			continue
+		}
+		current = append(current, line)
+	}
+
+	return blocks
+}
+
+// langBinaries maps common fenced-code-block language tags to the binary
+// that runs them, for cases where the tag isn't itself a binary name.
+var langBinaries = map[string]string{
+	"py":         "python3",
+	"python":     "python3",
+	"js":         "node",
+	"javascript": "node",
+	"rb":         "ruby",
+	"sh":         "sh",
+	"bash":       "bash",
+	"zsh":        "zsh",
+}
+
+// langBinary resolves a fenced code block's language tag to a binary path,
+// falling back to the shebang-derived binary when the tag is empty. A tag
+// that is not in langBinaries and not on PATH is returned unchanged.
+func langBinary(lang, fallback string) string {
+	if lang == "" {
+		return fallback
+	}
+	name := lang
+	if mapped, ok := langBinaries[lang]; ok {
+		name = mapped
+	}
+	if resolved, err := exec.LookPath(name); err == nil {
+		return resolved
+	}
+	return name
+}
+
+// runMarkdown executes the fenced code blocks of a Markdown document,
+// dispatching contiguous runs of same-language blocks to the wrapper for
+// that language, in document order.
+func runMarkdown(scriptPath, shebangBinary string) {
+	content, err := os.ReadFile(scriptPath)
+	if err != nil {
+		die(fmt.Sprintf("could not read script: %v", err))
+	}
+
+	blocks := splitBlocksMarkdown(string(content))
+	if len(blocks) == 0 {
+		die("no fenced code blocks found in script")
+	}
+
+	// Group contiguous blocks that resolve to the same binary so each run
+	// is dispatched to its wrapper in a single batch, same as the
+	// blank-line and hierarchical modes.
+	type run struct {
+		binary string
+		blocks []string
+	}
+	var runs []run
+	for _, b := range blocks {
+		binary := langBinary(b.lang, shebangBinary)
+		if binary == "" {
+			die(fmt.Sprintf("could not determine interpreter for block with language %q", b.lang))
+		}
+		if len(runs) > 0 && runs[len(runs)-1].binary == binary {
+			runs[len(runs)-1].blocks = append(runs[len(runs)-1].blocks, b.code)
+		} else {
+			runs = append(runs, run{binary: binary, blocks: []string{b.code}})
+		}
+	}
+
+	executed := false
+	for _, r := range runs {
+		wrapper := findWrapper(r.binary)
+		if wrapper == "" {
+			// Tutorial-style fences (```text, ```json, ```output, ...) don't
+			// name anything executable; skip them rather than aborting the
+			// whole run over a fence nobody meant to run.
+			fmt.Fprintf(os.Stderr, "skipping block(s): no wrapper found for %s (basename: %s)\n", r.binary, filepath.Base(r.binary))
+			continue
+		}
+		executed = true
+
+		wrapperArgs := append([]string{"--binary", r.binary, "--"}, r.blocks...)
+		cmd := exec.Command(wrapper, wrapperArgs...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			die(fmt.Sprintf("could not execute wrapper: %v", err))
+		}
+	}
+
+	if !executed {
+		die("no fenced code block resolved to a runnable interpreter")
+	}
+}
+
+// notebookSource flattens a parsed .ipynb cell's "source" field, which per
+// nbformat may be either a single string or a list of line strings.
+func notebookSource(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []interface{}:
+		var sb strings.Builder
+		for _, line := range t {
+			if s, ok := line.(string); ok {
+				sb.WriteString(s)
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// notebookKernel reads the kernel name and language out of a parsed
+// notebook's metadata.kernelspec.
+func notebookKernel(nb map[string]interface{}) (name, language string) {
+	meta, _ := nb["metadata"].(map[string]interface{})
+	if meta == nil {
+		return "", ""
+	}
+	ks, _ := meta["kernelspec"].(map[string]interface{})
+	if ks == nil {
+		return "", ""
+	}
+	name, _ = ks["name"].(string)
+	language, _ = ks["language"].(string)
+	return name, language
+}
+
+// notebookTextLines splits s into the line-per-element form nbformat uses
+// for stream output "text" fields.
+func notebookTextLines(s string) []interface{} {
+	if s == "" {
+		return []interface{}{}
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	out := make([]interface{}, len(lines))
+	for i, l := range lines {
+		out[i] = l
+	}
+	return out
+}
+
+// notebookOutputs builds an nbformat "outputs" array from a block's
+// captured stdout/stderr and exit status.
+func notebookOutputs(stdout, stderr string, exitCode int) []interface{} {
+	var outputs []interface{}
+	if stdout != "" {
+		outputs = append(outputs, map[string]interface{}{
+			"output_type": "stream",
+			"name":        "stdout",
+			"text":        notebookTextLines(stdout),
+		})
+	}
+	if stderr != "" {
+		outputs = append(outputs, map[string]interface{}{
+			"output_type": "stream",
+			"name":        "stderr",
+			"text":        notebookTextLines(stderr),
+		})
+	}
+	if exitCode != 0 {
+		outputs = append(outputs, map[string]interface{}{
+			"output_type": "error",
+			"ename":       "ExecutionError",
+			"evalue":      fmt.Sprintf("exit status %d", exitCode),
+			"traceback":   []interface{}{},
+		})
+	}
+	return outputs
+}
+
+// runNotebook executes the code cells of a .ipynb file. If outputPath is
+// empty, all cells are batched into a single wrapper invocation exactly
+// like the other block-splitting modes. Otherwise each cell is run as its
+// own subprocess so its stdout, stderr and exit status can be captured and
+// written back into a new notebook at outputPath.
+func runNotebook(scriptPath, outputPath string) {
+	data, err := os.ReadFile(scriptPath)
+	if err != nil {
+		die(fmt.Sprintf("could not read script: %v", err))
+	}
+
+	var nb map[string]interface{}
+	if err := json.Unmarshal(data, &nb); err != nil {
+		die(fmt.Sprintf("could not parse notebook: %v", err))
+	}
+
+	cells, _ := nb["cells"].([]interface{})
+
+	kernelName, kernelLanguage := notebookKernel(nb)
+	binary := langBinary(kernelLanguage, kernelName)
+	if binary == "" {
+		die("could not determine kernel from notebook metadata.kernelspec")
+	}
+
+	wrapper := findWrapper(binary)
+	if wrapper == "" {
+		die(fmt.Sprintf("no wrapper found for: %s (basename: %s)", binary, filepath.Base(binary)))
+	}
+
+	var codeCellIdx []int
+	var blocks []string
+	for i, c := range cells {
+		cell, ok := c.(map[string]interface{})
+		if !ok || cell["cell_type"] != "code" {
+			continue
+		}
+		codeCellIdx = append(codeCellIdx, i)
+		blocks = append(blocks, notebookSource(cell["source"]))
+	}
+
+	if len(blocks) == 0 {
+		die("no code cells found in notebook")
+	}
+
+	if outputPath == "" {
+		wrapperArgs := []string{wrapper, "--binary", binary, "--"}
+		wrapperArgs = append(wrapperArgs, blocks...)
+		if err := syscall.Exec(wrapper, wrapperArgs, os.Environ()); err != nil {
+			die(fmt.Sprintf("could not execute wrapper: %v", err))
+		}
+		return
+	}
+
+	execCount := 0
+	for i, block := range blocks {
+		execCount++
+
+		cmd := exec.Command(wrapper, "--binary", binary, "--", block)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+		exitCode := 0
+		if runErr := cmd.Run(); runErr != nil {
+			exitErr, ok := runErr.(*exec.ExitError)
+			if !ok {
+				die(fmt.Sprintf("could not execute wrapper: %v", runErr))
+			}
+			exitCode = exitErr.ExitCode()
+		}
+
+		cell := cells[codeCellIdx[i]].(map[string]interface{})
+		cell["execution_count"] = execCount
+		cell["outputs"] = notebookOutputs(stdout.String(), stderr.String(), exitCode)
+	}
+
+	out, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		die(fmt.Sprintf("could not encode notebook: %v", err))
+	}
+	if err := os.WriteFile(outputPath, append(out, '\n'), 0644); err != nil {
+		die(fmt.Sprintf("could not write notebook: %v", err))
+	}
+}
+
+// Session protocol framing. A session wrapper writes a block's stdout and
+// stderr to the respective streams as it normally would, then terminates
+// each stream with a line starting with sessionEndPrefix, so block-run
+// knows where one block's output ends and the next begins on each stream
+// independently. Only the stdout stream's end-marker carries the block's
+// exit status; the stderr one carries nothing after the prefix.
+//
+// The wrapper must ensure a block's own output ends with a newline before
+// writing the marker line — sessionEndPrefix is only recognized at the
+// start of a line, so output lacking a trailing newline will desync the
+// framing (the marker gets appended to the last output line instead of
+// read as its own line).
+const (
+	sessionBlockSentinel = "\x00BLOCK\x00"
+	sessionEndPrefix     = "\x00END:"
+)
+
+// findSessionWrapper returns the path to a session-capable wrapper for
+// binary, or "" if none is available. A wrapper is session-capable if a
+// "<basename>.session" executable exists alongside it in a wrapper
+// directory, or if the ordinary wrapper reports "session" support when
+// probed with --capabilities.
+func findSessionWrapper(binary string) string {
+	basename := filepath.Base(binary)
+	for _, dir := range wrapperDirs {
+		p := filepath.Join(dir, basename+".session")
+		if info, err := os.Stat(p); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return p
+		}
+	}
+
+	wrapper := findWrapper(binary)
+	if wrapper == "" {
+		return ""
+	}
+	out, err := exec.Command(wrapper, "--capabilities").Output()
+	if err == nil && strings.Contains(string(out), "session") {
+		return wrapper
+	}
+	return ""
+}
+
+// sessionStreamResult is one stream's outcome from readFramedStream: the
+// exit code parsed from the end marker (stdout only; always 0 for stderr),
+// and the read error, if the stream ended before the marker arrived.
+type sessionStreamResult struct {
+	exitCode int
+	err      error
+}
+
+// readFramedStream copies lines from r to out until it sees a line
+// starting with sessionEndPrefix, which it consumes instead of printing.
+// Any text after the prefix on that line is parsed as an exit code.
+func readFramedStream(r *bufio.Reader, out io.Writer) sessionStreamResult {
+	for {
+		line, readErr := r.ReadString('\n')
+		if strings.HasPrefix(line, sessionEndPrefix) {
+			var code int
+			fmt.Sscanf(strings.TrimSpace(strings.TrimPrefix(line, sessionEndPrefix)), "%d", &code)
+			return sessionStreamResult{exitCode: code}
+		}
+		if line != "" {
+			fmt.Fprint(out, line)
+		}
+		if readErr != nil {
+			return sessionStreamResult{err: readErr}
+		}
+	}
+}
+
+// runSession spawns a session wrapper once and feeds it each block in turn
+// over stdin, framed with sessionBlockSentinel, so the underlying
+// interpreter keeps running and variables persist across blocks. Both the
+// block's stdout and stderr are read back framed (see the session protocol
+// comment above) so they can be attributed to this block specifically,
+// rather than arriving unordered on an inherited stderr.
+func runSession(wrapper, binary string, blocks []string) {
+	cmd := exec.Command(wrapper, "--binary", binary, "--session")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		die(fmt.Sprintf("could not open session stdin: %v", err))
+	}
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		die(fmt.Sprintf("could not open session stdout: %v", err))
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		die(fmt.Sprintf("could not open session stderr: %v", err))
+	}
+
+	if err := cmd.Start(); err != nil {
+		die(fmt.Sprintf("could not start session wrapper: %v", err))
+	}
+
+	stdoutReader := bufio.NewReader(stdoutPipe)
+	stderrReader := bufio.NewReader(stderrPipe)
+	failed := false
+	firstFailureExit := 0
+
+	for i, block := range blocks {
+		if _, err := io.WriteString(stdin, block+"\n"+sessionBlockSentinel+"\n"); err != nil {
+			die(fmt.Sprintf("could not write block to session: %v", err))
+		}
+
+		stdoutDone := make(chan sessionStreamResult, 1)
+		stderrDone := make(chan sessionStreamResult, 1)
+		go func() { stdoutDone <- readFramedStream(stdoutReader, os.Stdout) }()
+		go func() { stderrDone <- readFramedStream(stderrReader, os.Stderr) }()
+		stdoutResult := <-stdoutDone
+		stderrResult := <-stderrDone
+
+		if stdoutResult.err != nil || stderrResult.err != nil {
+			// The wrapper closed a stream (crashed, or was killed) without
+			// ever signalling that this block finished on it.
+			waitErr := cmd.Wait()
+			die(fmt.Sprintf("session wrapper exited before block %d finished: %v", i+1, waitErr))
+		}
+
+		if stdoutResult.exitCode != 0 {
+			failed = true
+			if firstFailureExit == 0 {
+				firstFailureExit = stdoutResult.exitCode
+			}
+			fmt.Fprintf(os.Stderr, "block %d exited with status %d\n", i+1, stdoutResult.exitCode)
+		}
+	}
+
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		die(fmt.Sprintf("session wrapper exited with error: %v", err))
+	}
+
+	if failed {
+		os.Exit(firstFailureExit)
+	}
+}
+
+// Directives recognized at the start of a block in --test mode. They are
+// ordinary comment lines, so the wrapper still sees them as comments when
+// the block is executed.
+var (
+	expectOutputRe     = regexp.MustCompile(`^#\s*expect-output:\s?(.*)$`)
+	expectExitRe       = regexp.MustCompile(`^#\s*expect-exit:\s*(-?\d+)\s*$`)
+	expectErrorMatchRe = regexp.MustCompile(`^#\s*expect-error-match:\s*/(.*)/\s*$`)
+	skipDirectiveRe    = regexp.MustCompile(`^#\s*skip\s*$`)
+)
+
+// blockDirectives holds the parsed --test expectations for one block.
+type blockDirectives struct {
+	skip             bool
+	expectOutput     *string
+	expectOutputLine string // original "# expect-output: ..." line, for --update
+	expectExit       *int
+	expectErrorMatch *regexp.Regexp
+}
+
+// parseTestDirectives scans a block's leading comment lines for
+// expect-output, expect-exit, expect-error-match and skip comments,
+// stopping at the first line that is neither blank nor a comment.
+func parseTestDirectives(block string) blockDirectives {
+	var d blockDirectives
+	for _, line := range strings.Split(block, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		if m := expectOutputRe.FindStringSubmatch(trimmed); m != nil {
+			// --update escapes embedded newlines as the literal two
+			// characters "\n" so a multi-line expectation stays a single
+			// directive line; decode that back before comparing.
+			out := strings.ReplaceAll(m[1], "\\n", "\n")
+			d.expectOutput = &out
+			d.expectOutputLine = line
+			continue
+		}
+		if m := expectExitRe.FindStringSubmatch(trimmed); m != nil {
+			var code int
+			fmt.Sscanf(m[1], "%d", &code)
+			d.expectExit = &code
+			continue
+		}
+		if m := expectErrorMatchRe.FindStringSubmatch(trimmed); m != nil {
+			if re, err := regexp.Compile(m[1]); err == nil {
+				d.expectErrorMatch = re
+			}
+			continue
+		}
+		if skipDirectiveRe.MatchString(trimmed) {
+			d.skip = true
+		}
+	}
+	return d
+}
+
+// runTest runs each block through the wrapper, checks its captured output
+// against that block's expect-* directives, and reports a pass/fail/skip
+// summary. If update is true, stale expect-output directives are rewritten
+// in scriptPath to match the observed output.
+func runTest(scriptPath, shebang, contentWithoutShebang, wrapper, binary string, blocks []string, update bool) {
+	passed, failed, skipped := 0, 0, 0
+	updated := contentWithoutShebang
+	searchFrom := 0
+
+	for i, block := range blocks {
+		// Locate this exact block's text in updated, searching forward from
+		// the end of the previous block, so a rewrite below only ever
+		// touches this block's own directive line, never an identical line
+		// belonging to an earlier or later block.
+		blockPos := strings.Index(updated[searchFrom:], block)
+		if blockPos == -1 {
+			die(fmt.Sprintf("internal error: could not relocate block %d while updating %s", i+1, scriptPath))
+		}
+		blockPos += searchFrom
+		blockEnd := blockPos + len(block)
+		searchFrom = blockEnd
+
+		dirs := parseTestDirectives(block)
+		if dirs.skip {
+			skipped++
+			fmt.Printf("SKIP block %d\n", i+1)
+			continue
+		}
+
+		cmd := exec.Command(wrapper, "--binary", binary, "--", block)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		exitCode := 0
+		if runErr := cmd.Run(); runErr != nil {
+			exitErr, ok := runErr.(*exec.ExitError)
+			if !ok {
+				die(fmt.Sprintf("could not execute wrapper: %v", runErr))
+			}
+			exitCode = exitErr.ExitCode()
+		}
+		observedOutput := strings.TrimRight(stdout.String(), "\n")
+
+		var failures []string
+		if dirs.expectOutput != nil && observedOutput != *dirs.expectOutput {
+			failures = append(failures, fmt.Sprintf("expected output %q, got %q", *dirs.expectOutput, observedOutput))
+		}
+		if dirs.expectExit != nil && exitCode != *dirs.expectExit {
+			failures = append(failures, fmt.Sprintf("expected exit %d, got %d", *dirs.expectExit, exitCode))
+		}
+		if dirs.expectErrorMatch != nil && !dirs.expectErrorMatch.MatchString(stderr.String()) {
+			failures = append(failures, fmt.Sprintf("stderr did not match /%s/", dirs.expectErrorMatch.String()))
+		}
+
+		if len(failures) == 0 {
+			passed++
+			fmt.Printf("ok   block %d\n", i+1)
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAIL block %d: %s\n", i+1, strings.Join(failures, "; "))
+
+		if update && dirs.expectOutput != nil && observedOutput != *dirs.expectOutput {
+			indent := dirs.expectOutputLine[:len(dirs.expectOutputLine)-len(strings.TrimLeft(dirs.expectOutputLine, " \t"))]
+			newLine := indent + "# expect-output: " + strings.ReplaceAll(observedOutput, "\n", "\\n")
+
+			segment := updated[blockPos:blockEnd]
+			newSegment := strings.Replace(segment, dirs.expectOutputLine, newLine, 1)
+			updated = updated[:blockPos] + newSegment + updated[blockEnd:]
+
+			blockEnd += len(newSegment) - len(segment)
+			searchFrom = blockEnd
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+
+	if update {
+		if err := os.WriteFile(scriptPath, []byte(shebang+"\n"+updated), 0644); err != nil {
+			die(fmt.Sprintf("could not update script: %v", err))
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runBlockOnce executes a single block through the wrapper's single-block
+// calling convention (--block instead of batching all blocks after --),
+// inheriting stdio so output appears immediately. It returns the block's
+// exit status.
+func runBlockOnce(wrapper, binary, block string) int {
+	cmd := exec.Command(wrapper, "--binary", binary, "--block", "--", block)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		die(fmt.Sprintf("could not execute wrapper: %v", err))
+	}
+	return 0
+}
+
+// editBlock opens block in $EDITOR (falling back to vi) and returns the
+// edited contents.
+func editBlock(block string) (string, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	tmp, err := os.CreateTemp("", "block-run-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(block); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	tmp.Close()
+
+	cmd := exec.Command(editor, tmp.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// runInteractive executes blocks one at a time, pausing after each for the
+// user to continue, skip the next block, re-run the previous one, edit and
+// re-run the current one, or quit.
+func runInteractive(wrapper, binary string, blocks []string) {
+	reader := bufio.NewReader(os.Stdin)
+	i := 0
+	skipNext := false
+
+	for i < len(blocks) {
+		if skipNext {
+			fmt.Printf("-- skipping block %d --\n", i+1)
+			skipNext = false
+			i++
+			continue
+		}
+
+		fmt.Printf("-- block %d/%d --\n", i+1, len(blocks))
+		runBlockOnce(wrapper, binary, blocks[i])
+
+		for advance := false; !advance; {
+			fmt.Print("[enter] continue, (s)kip next, (r)e-run previous, (e)dit, (q)uit: ")
+			line, _ := reader.ReadString('\n')
+			switch strings.TrimSpace(line) {
+			case "":
+				i++
+				advance = true
+			case "s":
+				skipNext = true
+				i++
+				advance = true
+			case "r":
+				if i > 0 {
+					fmt.Printf("-- re-running block %d --\n", i)
+					runBlockOnce(wrapper, binary, blocks[i-1])
+				}
+			case "e":
+				edited, err := editBlock(blocks[i])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					continue
+				}
+				blocks[i] = edited
+				fmt.Printf("-- re-running edited block %d --\n", i+1)
+				runBlockOnce(wrapper, binary, blocks[i])
+			case "q":
+				return
+			default:
+				fmt.Println("unrecognized action")
+			}
+		}
+	}
+}
+
 // splitBlocksHierarchical splits content into blocks separated by ## headers.
 func splitBlocksHierarchical(content string) []string {
 	var blocks []string
@@ -215,10 +933,190 @@ func splitBlocksHierarchical(content string) []string {
 	return blocks
 }
 
+// blockNode is a block annotated with the name and dependencies declared on
+// its first line, used to drive --only, --from and --list.
+type blockNode struct {
+	name    string
+	depends []string
+	body    string
+}
+
+var blockDirectiveRe = regexp.MustCompile(`^#\s*block:\s*(.*)$`)
+
+// parseNameDepends splits "name depends: a, b" (or just "name") into the
+// name and the list of dependency names.
+func parseNameDepends(rest string) (string, []string) {
+	idx := strings.Index(rest, "depends:")
+	if idx == -1 {
+		return strings.TrimSpace(rest), nil
+	}
+	name := strings.TrimSpace(rest[:idx])
+	var deps []string
+	for _, d := range strings.Split(rest[idx+len("depends:"):], ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			deps = append(deps, d)
+		}
+	}
+	return name, deps
+}
+
+// buildBlockGraph annotates each block with the name/depends metadata on
+// its first line: a "## " header in hierarchical mode, or a "# block:"
+// directive in blank-line mode. Blocks without either are left unnamed and
+// can still run, just not be targeted by name.
+func buildBlockGraph(blocks []string, hierarchical bool) []blockNode {
+	nodes := make([]blockNode, len(blocks))
+	for i, b := range blocks {
+		firstLine := b
+		if idx := strings.IndexByte(b, '\n'); idx != -1 {
+			firstLine = b[:idx]
+		}
+
+		var name string
+		var depends []string
+		if hierarchical {
+			if rest := strings.TrimPrefix(firstLine, "## "); rest != firstLine {
+				name, depends = parseNameDepends(rest)
+			}
+		} else if m := blockDirectiveRe.FindStringSubmatch(firstLine); m != nil {
+			name, depends = parseNameDepends(m[1])
+		}
+
+		nodes[i] = blockNode{name: name, depends: depends, body: b}
+	}
+	return nodes
+}
+
+// printBlockGraph prints the discovered blocks and their dependencies
+// without running anything, for --list.
+func printBlockGraph(nodes []blockNode) {
+	for i, n := range nodes {
+		name := n.name
+		if name == "" {
+			name = fmt.Sprintf("(block %d)", i+1)
+		}
+		if len(n.depends) > 0 {
+			fmt.Printf("%d: %s (depends: %s)\n", i+1, name, strings.Join(n.depends, ", "))
+		} else {
+			fmt.Printf("%d: %s\n", i+1, name)
+		}
+	}
+}
+
+// collectDeps adds idx and everything it transitively depends on to
+// selected.
+func collectDeps(idx int, nodes []blockNode, nameToIndex map[string]int, selected map[int]bool) {
+	if selected[idx] {
+		return
+	}
+	selected[idx] = true
+	for _, dep := range nodes[idx].depends {
+		di, ok := nameToIndex[dep]
+		if !ok {
+			die(fmt.Sprintf("unknown dependency %q", dep))
+		}
+		collectDeps(di, nodes, nameToIndex, selected)
+	}
+}
+
+// topoSort orders the indices in selected so each block comes after
+// everything it depends on, breaking ties by original script order.
+func topoSort(selected map[int]bool, nodes []blockNode, nameToIndex map[string]int) []int {
+	indices := make([]int, 0, len(selected))
+	for idx := range selected {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	visited := make(map[int]bool, len(selected))
+	var order []int
+	var visit func(int)
+	visit = func(idx int) {
+		if visited[idx] {
+			return
+		}
+		visited[idx] = true
+		for _, dep := range nodes[idx].depends {
+			if di, ok := nameToIndex[dep]; ok && selected[di] {
+				visit(di)
+			}
+		}
+		order = append(order, idx)
+	}
+	for _, idx := range indices {
+		visit(idx)
+	}
+	return order
+}
+
+// nameIndex maps each named block to its index, for --only/--from/--list
+// lookups.
+func nameIndex(nodes []blockNode) map[string]int {
+	index := make(map[string]int, len(nodes))
+	for i, n := range nodes {
+		if n.name != "" {
+			index[n.name] = i
+		}
+	}
+	return index
+}
+
+// selectOnly returns the named block plus its transitive dependencies, in
+// dependency order.
+func selectOnly(nodes []blockNode, name string) []string {
+	nameToIndex := nameIndex(nodes)
+	idx, ok := nameToIndex[name]
+	if !ok {
+		die(fmt.Sprintf("no block named %q", name))
+	}
+
+	selected := map[int]bool{}
+	collectDeps(idx, nodes, nameToIndex, selected)
+
+	order := topoSort(selected, nodes, nameToIndex)
+	blocks := make([]string, len(order))
+	for i, idx := range order {
+		blocks[i] = nodes[idx].body
+	}
+	return blocks
+}
+
+// selectFrom returns the named block and every block after it in the
+// script, plus any transitive dependencies pulled in from earlier, in
+// dependency order.
+func selectFrom(nodes []blockNode, name string) []string {
+	nameToIndex := nameIndex(nodes)
+	start, ok := nameToIndex[name]
+	if !ok {
+		die(fmt.Sprintf("no block named %q", name))
+	}
+
+	selected := map[int]bool{}
+	for i := start; i < len(nodes); i++ {
+		collectDeps(i, nodes, nameToIndex, selected)
+	}
+
+	order := topoSort(selected, nodes, nameToIndex)
+	blocks := make([]string, len(order))
+	for i, idx := range order {
+		blocks[i] = nodes[idx].body
+	}
+	return blocks
+}
+
 func main() {
 	// Parse arguments
 	var scriptPath string
+	var outputIpynb string
+	var only string
+	var from string
 	hierarchical := false
+	markdown := false
+	session := false
+	test := false
+	update := false
+	interactive := false
+	list := false
 
 	args := os.Args[1:]
 	for i := 0; i < len(args); i++ {
@@ -228,6 +1126,36 @@ func main() {
 			os.Exit(0)
 		case "--hierarchical":
 			hierarchical = true
+		case "--markdown":
+			markdown = true
+		case "--session":
+			session = true
+		case "--test":
+			test = true
+		case "--update":
+			update = true
+		case "--interactive":
+			interactive = true
+		case "--list":
+			list = true
+		case "--output-ipynb":
+			if i+1 >= len(args) {
+				die("--output-ipynb requires a path")
+			}
+			i++
+			outputIpynb = args[i]
+		case "--only":
+			if i+1 >= len(args) {
+				die("--only requires a block name")
+			}
+			i++
+			only = args[i]
+		case "--from":
+			if i+1 >= len(args) {
+				die("--from requires a block name")
+			}
+			i++
+			from = args[i]
 		default:
 			if strings.HasPrefix(args[i], "-") {
 				die(fmt.Sprintf("unknown option: %s", args[i]))
@@ -243,6 +1171,50 @@ func main() {
 		die("no script specified")
 	}
 
+	if update && !test {
+		die("--update requires --test")
+	}
+
+	if only != "" && from != "" {
+		die("--only and --from are mutually exclusive")
+	}
+
+	if outputIpynb != "" && !strings.HasSuffix(scriptPath, ".ipynb") {
+		die("--output-ipynb requires .ipynb input")
+	}
+
+	// --session, --test and --interactive are mutually exclusive execution
+	// modes; silently preferring one over another would mean e.g. --test's
+	// assertions never run. Reject the combination instead of picking a
+	// winner.
+	execModes := 0
+	for _, on := range []bool{session, test, interactive} {
+		if on {
+			execModes++
+		}
+	}
+	if execModes > 1 {
+		die("--session, --test and --interactive are mutually exclusive")
+	}
+
+	// --only, --from, --list, --test, --interactive and --session all
+	// operate on the []string blocks produced by the shebang-driven
+	// splitters below; none of them are wired up for the .ipynb or
+	// --markdown input paths, so reject the combination instead of
+	// silently ignoring the flag.
+	unsupported := only != "" || from != "" || list || test || interactive || session
+	if unsupported && strings.HasSuffix(scriptPath, ".ipynb") {
+		die("--only, --from, --list, --test, --interactive and --session are not supported with .ipynb input")
+	}
+	if unsupported && markdown {
+		die("--only, --from, --list, --test, --interactive and --session are not supported with --markdown")
+	}
+
+	if strings.HasSuffix(scriptPath, ".ipynb") {
+		runNotebook(scriptPath, outputIpynb)
+		return
+	}
+
 	// Read the script
 	content, err := os.ReadFile(scriptPath)
 	if err != nil {
@@ -254,6 +1226,18 @@ func main() {
 		die("script is empty")
 	}
 
+	if markdown {
+		// A shebang is optional in Markdown mode; if present it's only a
+		// fallback for fenced blocks with no info string, so per-block
+		// language tags still take precedence.
+		fallback := ""
+		if strings.HasPrefix(lines[0], "#!") {
+			fallback = parseShebang(lines[0])
+		}
+		runMarkdown(scriptPath, fallback)
+		return
+	}
+
 	// Parse shebang
 	shebang := lines[0]
 	if !strings.HasPrefix(shebang, "#!") {
@@ -285,6 +1269,38 @@ func main() {
 		die("no blocks found in script")
 	}
 
+	if list || only != "" || from != "" {
+		nodes := buildBlockGraph(blocks, hierarchical)
+		switch {
+		case list:
+			printBlockGraph(nodes)
+			return
+		case only != "":
+			blocks = selectOnly(nodes, only)
+		case from != "":
+			blocks = selectFrom(nodes, from)
+		}
+	}
+
+	if session {
+		sessionWrapper := findSessionWrapper(binary)
+		if sessionWrapper == "" {
+			die(fmt.Sprintf("no session-capable wrapper found for: %s (basename: %s)", binary, filepath.Base(binary)))
+		}
+		runSession(sessionWrapper, binary, blocks)
+		return
+	}
+
+	if test {
+		runTest(scriptPath, shebang, contentWithoutShebang, wrapper, binary, blocks, update)
+		return
+	}
+
+	if interactive {
+		runInteractive(wrapper, binary, blocks)
+		return
+	}
+
 	// Build wrapper arguments
 	wrapperArgs := []string{wrapper, "--binary", binary, "--"}
 	wrapperArgs = append(wrapperArgs, blocks...)
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestSplitBlocksMarkdown(t *testing.T) {
+	content := "Some prose.\n\n" +
+		"```bash\n" +
+		"echo one\n" +
+		"```\n\n" +
+		"More prose in between.\n\n" +
+		"```python\n" +
+		"print(2)\n" +
+		"```\n\n" +
+		"```\n" +
+		"no lang tag\n" +
+		"```\n"
+
+	blocks := splitBlocksMarkdown(content)
+	if len(blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3: %+v", len(blocks), blocks)
+	}
+
+	want := []mdBlock{
+		{lang: "bash", code: "echo one"},
+		{lang: "python", code: "print(2)"},
+		{lang: "", code: "no lang tag"},
+	}
+	for i, b := range blocks {
+		if b.lang != want[i].lang || b.code != want[i].code {
+			t.Errorf("block %d = %+v, want %+v", i, b, want[i])
+		}
+	}
+}
+
+func TestSplitBlocksMarkdownIgnoresProse(t *testing.T) {
+	content := "# Title\n\nNot a code block.\n\n```sh\ntrue\n```\n"
+	blocks := splitBlocksMarkdown(content)
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1: %+v", len(blocks), blocks)
+	}
+	if blocks[0].code != "true" {
+		t.Errorf("code = %q, want %q", blocks[0].code, "true")
+	}
+}
+
+func TestNotebookSource(t *testing.T) {
+	if got := notebookSource("print(1)"); got != "print(1)" {
+		t.Errorf("string source: got %q", got)
+	}
+	got := notebookSource([]interface{}{"import os\n", "print(os.getcwd())"})
+	want := "import os\nprint(os.getcwd())"
+	if got != want {
+		t.Errorf("list source: got %q, want %q", got, want)
+	}
+}
+
+func TestNotebookOutputsStreamsAndError(t *testing.T) {
+	outputs := notebookOutputs("line1\nline2\n", "", 0)
+	want := []interface{}{
+		map[string]interface{}{
+			"output_type": "stream",
+			"name":        "stdout",
+			"text":        []interface{}{"line1\n", "line2\n"},
+		},
+	}
+	if !reflect.DeepEqual(outputs, want) {
+		t.Errorf("got %+v, want %+v", outputs, want)
+	}
+
+	outputs = notebookOutputs("", "boom\n", 1)
+	if len(outputs) != 2 {
+		t.Fatalf("got %d outputs, want 2 (stderr stream + error): %+v", len(outputs), outputs)
+	}
+	errOut := outputs[1].(map[string]interface{})
+	if errOut["output_type"] != "error" || errOut["evalue"] != "exit status 1" {
+		t.Errorf("error output = %+v", errOut)
+	}
+}
+
+func TestNotebookOutputsEmpty(t *testing.T) {
+	if outputs := notebookOutputs("", "", 0); len(outputs) != 0 {
+		t.Errorf("got %d outputs for empty/successful block, want 0: %+v", len(outputs), outputs)
+	}
+}
+
+func TestNotebookKernel(t *testing.T) {
+	nb := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"kernelspec": map[string]interface{}{
+				"name":     "python3",
+				"language": "python",
+			},
+		},
+	}
+	name, lang := notebookKernel(nb)
+	if name != "python3" || lang != "python" {
+		t.Errorf("got name=%q lang=%q, want name=python3 lang=python", name, lang)
+	}
+
+	if name, lang := notebookKernel(map[string]interface{}{}); name != "" || lang != "" {
+		t.Errorf("missing metadata: got name=%q lang=%q, want empty", name, lang)
+	}
+}
+
+func TestParseNameDepends(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantName string
+		wantDeps []string
+	}{
+		{"setup", "setup", nil},
+		{"build depends: fetch, configure", "build", []string{"fetch", "configure"}},
+		{"analysis depends:   setup  ", "analysis", []string{"setup"}},
+		{"  spaced  ", "spaced", nil},
+	}
+	for _, c := range cases {
+		name, deps := parseNameDepends(c.in)
+		if name != c.wantName || !reflect.DeepEqual(deps, c.wantDeps) {
+			t.Errorf("parseNameDepends(%q) = (%q, %v), want (%q, %v)", c.in, name, deps, c.wantName, c.wantDeps)
+		}
+	}
+}
+
+func TestTopoSortOrdersDependenciesFirst(t *testing.T) {
+	// fetch -> configure -> build -> analysis, with "build" selected
+	// pulling in everything it transitively depends on.
+	nodes := []blockNode{
+		{name: "fetch", body: "fetch"},
+		{name: "configure", depends: []string{"fetch"}, body: "configure"},
+		{name: "build", depends: []string{"configure"}, body: "build"},
+		{name: "analysis", depends: []string{"build"}, body: "analysis"},
+	}
+	nameToIndex := nameIndex(nodes)
+
+	selected := map[int]bool{}
+	collectDeps(2, nodes, nameToIndex, selected) // "build"
+
+	if len(selected) != 3 || selected[3] {
+		t.Fatalf("collectDeps selected = %v, want {0,1,2} only (not analysis)", selected)
+	}
+
+	order := topoSort(selected, nodes, nameToIndex)
+	wantOrder := []int{0, 1, 2} // fetch, configure, build
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("topoSort order = %v, want %v", order, wantOrder)
+	}
+}
+
+func TestCollectDepsUnknownDependencyDies(t *testing.T) {
+	if os.Getenv("BLOCK_RUN_TEST_DIE_SUBPROCESS") == "1" {
+		nodes := []blockNode{{name: "a", depends: []string{"missing"}, body: "a"}}
+		collectDeps(0, nodes, nameIndex(nodes), map[int]bool{})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestCollectDepsUnknownDependencyDies")
+	cmd.Env = append(os.Environ(), "BLOCK_RUN_TEST_DIE_SUBPROCESS=1")
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() == 0 {
+		t.Fatalf("expected collectDeps to exit nonzero on an unknown dependency, got err=%v", err)
+	}
+}